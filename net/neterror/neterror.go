@@ -5,38 +5,36 @@
 // Package neterror classifies network errors.
 package neterror
 
-import (
-	"errors"
-	"runtime"
-	"syscall"
-)
-
-var errEPERM error = syscall.EPERM // box it into interface just once
-
 // TreatAsLostUDP reports whether err is an error from a UDP send
 // operation that should be treated as a UDP packet that just got
 // lost.
 //
-// Notably, on Linux this reports true for EPERM errors (from outbound
-// firewall blocks) which aren't really send errors; they're just
-// sends that are never going to make it because the local OS blocked
-// it.
+// Notably, this reports true for errors like Linux's EPERM (from
+// outbound firewall blocks) or Windows' WSAEACCES (from a Windows
+// Firewall / WFP block), which aren't really send errors; they're
+// just sends that are never going to make it because the local OS
+// blocked them.
+//
+// The exact set of errors treated as lost is platform-specific; see
+// the neterror_GOOS.go files in this package.
 func TreatAsLostUDP(err error) bool {
 	if err == nil {
 		return false
 	}
-	switch runtime.GOOS {
-	case "linux":
-		// Linux, while not documented in the man page,
-		// returns EPERM when there's an OUTPUT rule with -j
-		// DROP or -j REJECT.  We use this very specific
-		// Linux+EPERM check rather than something super broad
-		// like net.Error.Temporary which could be anything.
-		//
-		// For now we only do this on Linux, as such outgoing
-		// firewall violations mapping to syscall errors
-		// hasn't yet been observed on other OSes.
-		return errors.Is(err, errEPERM)
+	return treatAsLostUDP(err)
+}
+
+// IsRoutingErr reports whether err indicates that the local network
+// stack has no route to the destination, as opposed to a packet being
+// actively dropped by local policy (see TreatAsLostUDP).
+//
+// Callers such as magicsock can use this distinction to make smarter
+// DERP fallback decisions: falling back to DERP can help route around
+// a local policy block, but it can't conjure up a route that doesn't
+// exist.
+func IsRoutingErr(err error) bool {
+	if err == nil {
+		return false
 	}
-	return false
+	return isRoutingErr(err)
 }
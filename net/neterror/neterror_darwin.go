@@ -0,0 +1,27 @@
+// Copyright (c) 2021 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package neterror
+
+import (
+	"errors"
+	"syscall"
+)
+
+func treatAsLostUDP(err error) bool {
+	// On a UDP socket bound to a specific interface, a Wi-Fi flap
+	// (or a route that's simply gone from the routing table) shows
+	// up as EHOSTUNREACH or ENETDOWN from sendto, not as a real send
+	// error. Treat both as a dropped packet.
+	return errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETDOWN)
+}
+
+func isRoutingErr(err error) bool {
+	// EHOSTUNREACH specifically means the routing table has no route
+	// to the destination. ENETDOWN means the local interface itself
+	// is down (e.g. a Wi-Fi flap); that's still worth treating as a
+	// lost packet above, but it's not the same as "no route exists",
+	// so it's deliberately excluded here.
+	return errors.Is(err, syscall.EHOSTUNREACH)
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2021 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package neterror
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errEPERM error = syscall.EPERM // box it into interface just once
+
+func treatAsLostUDP(err error) bool {
+	// Linux, while not documented in the man page, returns EPERM
+	// when there's an OUTPUT rule with -j DROP or -j REJECT. We use
+	// this very specific Linux+EPERM check rather than something
+	// super broad like net.Error.Temporary which could be anything.
+	return errors.Is(err, errEPERM)
+}
+
+func isRoutingErr(err error) bool {
+	return errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH)
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2021 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package neterror
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestLinuxClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantLost    bool
+		wantRouting bool
+	}{
+		{"eperm", wrapSendto(syscall.EPERM), true, false},
+		{"enetunreach", wrapSendto(syscall.ENETUNREACH), false, true},
+		{"ehostunreach", wrapSendto(syscall.EHOSTUNREACH), false, true},
+		{"econnrefused", wrapSendto(syscall.ECONNREFUSED), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TreatAsLostUDP(tt.err); got != tt.wantLost {
+				t.Errorf("TreatAsLostUDP(%v) = %v, want %v", tt.err, got, tt.wantLost)
+			}
+			if got := IsRoutingErr(tt.err); got != tt.wantRouting {
+				t.Errorf("IsRoutingErr(%v) = %v, want %v", tt.err, got, tt.wantRouting)
+			}
+		})
+	}
+}
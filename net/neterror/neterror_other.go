@@ -0,0 +1,16 @@
+// Copyright (c) 2021 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows && !darwin
+// +build !linux,!windows,!darwin
+
+package neterror
+
+func treatAsLostUDP(err error) bool {
+	return false
+}
+
+func isRoutingErr(err error) bool {
+	return false
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2021 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows && !darwin
+// +build !linux,!windows,!darwin
+
+package neterror
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestFallbackClassification(t *testing.T) {
+	// The generic fallback doesn't know how to classify anything; it
+	// should always report false, regardless of errno.
+	err := wrapSendto(syscall.EPERM)
+	if TreatAsLostUDP(err) {
+		t.Error("TreatAsLostUDP = true, want false on the generic fallback")
+	}
+	if IsRoutingErr(err) {
+		t.Error("IsRoutingErr = true, want false on the generic fallback")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2021 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package neterror
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// wrapSendto wraps errno the way a real failed UDP sendto does: inside
+// an *os.SyscallError, inside a *net.OpError. Tests use this to prove
+// that TreatAsLostUDP/IsRoutingErr classify the underlying errno even
+// through that wrapping.
+func wrapSendto(errno syscall.Errno) error {
+	return &net.OpError{
+		Op:  "write",
+		Net: "udp",
+		Err: os.NewSyscallError("sendto", errno),
+	}
+}
+
+func TestNilError(t *testing.T) {
+	if TreatAsLostUDP(nil) {
+		t.Error("TreatAsLostUDP(nil) = true, want false")
+	}
+	if IsRoutingErr(nil) {
+		t.Error("IsRoutingErr(nil) = true, want false")
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2021 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package neterror
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows Sockets error codes, from
+// https://docs.microsoft.com/en-us/windows/win32/winsock/windows-sockets-error-codes-2
+const (
+	wsaeaccess     syscall.Errno = 10013 // WSAEACCES: permission denied, e.g. a Windows Firewall/WFP block
+	wsaenetunreach syscall.Errno = 10051 // WSAENETUNREACH: no route to the network
+)
+
+func treatAsLostUDP(err error) bool {
+	// A sendto blocked by Windows Firewall (or another WFP filter)
+	// surfaces as WSAEACCES; a genuinely unreachable network
+	// surfaces as WSAENETUNREACH. Treat both as a dropped packet
+	// rather than a real send error.
+	return errors.Is(err, wsaeaccess) || errors.Is(err, wsaenetunreach)
+}
+
+func isRoutingErr(err error) bool {
+	return errors.Is(err, wsaenetunreach)
+}
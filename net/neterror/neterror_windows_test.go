@@ -0,0 +1,33 @@
+// Copyright (c) 2021 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package neterror
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestWindowsClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantLost    bool
+		wantRouting bool
+	}{
+		{"wsaeaccess", wrapSendto(wsaeaccess), true, false},
+		{"wsaenetunreach", wrapSendto(wsaenetunreach), true, true},
+		{"other", wrapSendto(syscall.Errno(10060)), false, false}, // WSAETIMEDOUT
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TreatAsLostUDP(tt.err); got != tt.wantLost {
+				t.Errorf("TreatAsLostUDP(%v) = %v, want %v", tt.err, got, tt.wantLost)
+			}
+			if got := IsRoutingErr(tt.err); got != tt.wantRouting {
+				t.Errorf("IsRoutingErr(%v) = %v, want %v", tt.err, got, tt.wantRouting)
+			}
+		})
+	}
+}
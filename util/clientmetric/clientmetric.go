@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -22,10 +23,12 @@ import (
 var (
 	mu          sync.Mutex // guards vars in this block
 	metrics     = map[string]*Metric{}
+	families    = map[string]string{} // family name => Prometheus "TYPE" keyword
+	histograms  = map[string]*Histogram{}
 	numWireID   int       // how many wireIDs have been allocated
 	lastDelta   time.Time // time of last call to EncodeLogTailMetricsDelta
 	sortedDirty bool      // whether sorted needs to be rebuilt
-	sorted      []*Metric // by name
+	sorted      []*Metric // by (family name, name)
 )
 
 // Type is a metric type: counter or gauge.
@@ -36,6 +39,15 @@ const (
 	TypeCounter
 )
 
+func (t Type) prometheusType() string {
+	switch t {
+	case TypeGauge:
+		return "gauge"
+	default:
+		return "counter"
+	}
+}
+
 // Metric is an integer metric value that's tracked over time.
 //
 // It's safe for concurrent use.
@@ -44,6 +56,12 @@ type Metric struct {
 	name string
 	typ  Type
 
+	// family is the name of the metric family (Vec or Histogram) that
+	// this Metric is a labeled or bucketed child of. It's empty for
+	// plain, unlabeled metrics, in which case name is also the family
+	// name.
+	family string
+
 	// Owned by package-level 'mu'.
 	wireID     int // zero until named
 	lastNamed  time.Time
@@ -54,6 +72,16 @@ func (m *Metric) Name() string { return m.name }
 func (m *Metric) Value() int64 { return atomic.LoadInt64(&m.v) }
 func (m *Metric) Type() Type   { return m.typ }
 
+// familyName returns the name of the metric family m belongs to: its
+// own name for plain metrics, or the Vec/Histogram name for a labeled
+// or bucketed child metric.
+func (m *Metric) familyName() string {
+	if m.family != "" {
+		return m.family
+	}
+	return m.name
+}
+
 // Add increments m's value by n.
 //
 // If m is of type counter, n should not be negative.
@@ -79,6 +107,12 @@ func (m *Metric) Publish() {
 	if _, dup := metrics[m.name]; dup {
 		panic("duplicate metric " + m.name)
 	}
+	if m.family == "" {
+		if _, dup := families[m.name]; dup {
+			panic("duplicate metric " + m.name)
+		}
+		families[m.name] = m.typ.prometheusType()
+	}
 	metrics[m.name] = m
 	sortedDirty = true
 }
@@ -95,7 +129,14 @@ func Metrics() []*Metric {
 		for _, m := range metrics {
 			sorted = append(sorted, m)
 		}
+		// Sort by family first so that all the children of a Vec or
+		// Histogram (which don't necessarily share a string prefix,
+		// e.g. "foo_sum" and "foo_bucket{...}") sort contiguously.
 		sort.Slice(sorted, func(i, j int) bool {
+			fi, fj := sorted[i].familyName(), sorted[j].familyName()
+			if fi != fj {
+				return fi < fj
+			}
 			return sorted[i].name < sorted[j].name
 		})
 	}
@@ -105,9 +146,17 @@ func Metrics() []*Metric {
 // NewUnpublished initializes a new Metric without calling Publish on
 // it.
 func NewUnpublished(name string, typ Type) *Metric {
+	checkMetricName(name)
+	return newMetric(name, typ)
+}
+
+func checkMetricName(name string) {
 	if i := strings.IndexFunc(name, isIllegalMetricRune); name == "" || i != -1 {
 		panic(fmt.Sprintf("illegal metric name %q (index %v)", name, i))
 	}
+}
+
+func newMetric(name string, typ Type) *Metric {
 	return &Metric{
 		name: name,
 		typ:  typ,
@@ -135,17 +184,232 @@ func NewGauge(name string) *Metric {
 	return m
 }
 
+// registerFamily records that name identifies a metric family (a Vec
+// or a Histogram) of the given Prometheus type keyword. It panics if
+// name has already been registered, as either a plain metric or
+// another family.
+func registerFamily(name, prometheusType string) {
+	checkMetricName(name)
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := families[name]; dup {
+		panic("duplicate metric family " + name)
+	}
+	families[name] = prometheusType
+}
+
+// MetricVec is a family of Metrics that share a name and a fixed set
+// of label names, as created by NewCounterVec or NewGaugeVec. Each
+// distinct combination of label values gets its own child *Metric,
+// created lazily by With.
+//
+// It's safe for concurrent use.
+type MetricVec struct {
+	name       string
+	typ        Type
+	labelNames []string
+
+	mu       sync.Mutex // guards children
+	children map[string]*Metric
+}
+
+// NewCounterVec returns a new family of counter metrics called name,
+// labeled by the given label names.
+func NewCounterVec(name string, labels ...string) *MetricVec {
+	return newMetricVec(name, TypeCounter, labels)
+}
+
+// NewGaugeVec returns a new family of gauge metrics called name,
+// labeled by the given label names.
+func NewGaugeVec(name string, labels ...string) *MetricVec {
+	return newMetricVec(name, TypeGauge, labels)
+}
+
+func newMetricVec(name string, typ Type, labelNames []string) *MetricVec {
+	if len(labelNames) == 0 {
+		panic("clientmetric: vec metric " + name + " needs at least one label")
+	}
+	registerFamily(name, typ.prometheusType())
+	return &MetricVec{
+		name:       name,
+		typ:        typ,
+		labelNames: append([]string(nil), labelNames...),
+		children:   map[string]*Metric{},
+	}
+}
+
+// With returns the child Metric for the given label values, creating
+// and publishing it if this is the first time this combination of
+// values has been seen. labelValues must be given in the same order
+// as the labels passed to NewCounterVec/NewGaugeVec.
+//
+// Concurrent calls to With for the same label values always return
+// the same *Metric.
+func (v *MetricVec) With(labelValues ...string) *Metric {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("clientmetric: vec %q: got %d label values, want %d", v.name, len(labelValues), len(v.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if m, ok := v.children[key]; ok {
+		return m
+	}
+	m := newMetric(promLabeledName(v.name, v.labelNames, labelValues), v.typ)
+	m.family = v.name
+	m.Publish()
+	v.children[key] = m
+	return m
+}
+
+// promLabeledName returns the Prometheus exposition form of a labeled
+// metric: name{k1="v1",k2="v2"}.
+func promLabeledName(name string, labelNames, labelValues []string) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	sb.WriteByte('{')
+	for i, k := range labelNames {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteString(`="`)
+		sb.WriteString(promEscapeLabelValue(labelValues[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func promEscapeLabelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return s
+}
+
+// Histogram tracks the distribution of observed values into a fixed
+// set of buckets, plus their count and sum, in the style of a
+// Prometheus histogram.
+//
+// It's safe for concurrent use.
+type Histogram struct {
+	name    string
+	buckets []int64 // ascending upper bounds ("le"), not including the implicit +Inf bucket
+
+	// bucketCounts[i] is the number of observations in (buckets[i-1], buckets[i]];
+	// bucketCounts[0] is the number of observations <= buckets[0].
+	// The final, extra element counts observations above the last
+	// finite bucket (the "+Inf" bucket).
+	//
+	// These are deliberately *not* cumulative: Observe only ever
+	// increments a single bucket, so EncodeLogTailMetricsDelta only
+	// has to encode the three wireIDs (that bucket, sum and count)
+	// that actually changed. WritePrometheusExpositionFormat does the
+	// cumulative summation at render time instead.
+	bucketCounts []*Metric
+
+	sum   *Metric
+	count *Metric
+}
+
+// NewHistogram returns a new Histogram called name, whose buckets have
+// the given upper bounds ("le" in Prometheus terms). buckets must be
+// in strictly ascending order; an implicit "+Inf" bucket is added
+// automatically.
+func NewHistogram(name string, buckets []int64) *Histogram {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			panic(fmt.Sprintf("clientmetric: histogram %q buckets not strictly ascending", name))
+		}
+	}
+	registerFamily(name, "histogram")
+
+	h := &Histogram{
+		name:         name,
+		buckets:      append([]int64(nil), buckets...),
+		bucketCounts: make([]*Metric, len(buckets)+1),
+	}
+	for i, le := range buckets {
+		m := newMetric(fmt.Sprintf("%s_bucket{le=%q}", name, strconv.FormatInt(le, 10)), TypeCounter)
+		m.family = name
+		m.Publish()
+		h.bucketCounts[i] = m
+	}
+	mInf := newMetric(fmt.Sprintf("%s_bucket{le=\"+Inf\"}", name), TypeCounter)
+	mInf.family = name
+	mInf.Publish()
+	h.bucketCounts[len(buckets)] = mInf
+
+	h.sum = newMetric(name+"_sum", TypeCounter)
+	h.sum.family = name
+	h.sum.Publish()
+	h.count = newMetric(name+"_count", TypeCounter)
+	h.count.family = name
+	h.count.Publish()
+
+	mu.Lock()
+	histograms[name] = h
+	mu.Unlock()
+
+	return h
+}
+
+// Observe records v as a new observation in h.
+func (h *Histogram) Observe(v int64) {
+	for i, le := range h.buckets {
+		if v <= le {
+			h.bucketCounts[i].Add(1)
+			h.sum.Add(v)
+			h.count.Add(1)
+			return
+		}
+	}
+	h.bucketCounts[len(h.buckets)].Add(1) // +Inf
+	h.sum.Add(v)
+	h.count.Add(1)
+}
+
+// writeProm writes h's standard bucket/sum/count triplet, including
+// its own "# TYPE ... histogram" header, to w.
+func (h *Histogram) writeProm(w io.Writer) {
+	fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+	var cumulative int64
+	for i, le := range h.buckets {
+		cumulative += h.bucketCounts[i].Value()
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, strconv.FormatInt(le, 10), cumulative)
+	}
+	cumulative += h.bucketCounts[len(h.buckets)].Value()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, cumulative)
+	fmt.Fprintf(w, "%s_sum %d\n", h.name, h.sum.Value())
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count.Value())
+}
+
 // WritePrometheusExpositionFormat writes all client metrics to w in
 // the Prometheus text-based exposition format.
 //
 // See https://github.com/prometheus/docs/blob/main/content/docs/instrumenting/exposition_formats.md
 func WritePrometheusExpositionFormat(w io.Writer) {
+	var lastFamily string
+	skippingHistogram := false
 	for _, m := range Metrics() {
-		switch m.Type() {
-		case TypeGauge:
-			fmt.Fprintf(w, "# TYPE %s gauge\n", m.Name())
-		case TypeCounter:
-			fmt.Fprintf(w, "# TYPE %s counter\n", m.Name())
+		fam := m.familyName()
+		if fam != lastFamily {
+			lastFamily = fam
+			mu.Lock()
+			h := histograms[fam]
+			typ := families[fam]
+			mu.Unlock()
+			if h != nil {
+				h.writeProm(w)
+				skippingHistogram = true
+				continue
+			}
+			skippingHistogram = false
+			fmt.Fprintf(w, "# TYPE %s %s\n", fam, typ)
+		} else if skippingHistogram {
+			continue
 		}
 		fmt.Fprintf(w, "%s %v\n", m.Name(), m.Value())
 	}
@@ -179,6 +443,13 @@ const (
 //     'S' + hex(varint(wireid)) + hex(varint(value))
 //   * increment a metric: (decrements if negative)
 //     'I' + hex(varint(wireid)) + hex(varint(value))
+//
+// Labeled metrics (MetricVec children) and Histogram buckets/sum/count
+// are each just ordinary Metrics under the hood, named by their
+// fully-rendered Prometheus form (e.g. "foo{bar=\"baz\"}" or
+// "foo_bucket{le=\"1\"}"), so they're encoded exactly like any other
+// metric: each gets its own wireID the first time it changes, and only
+// the metrics that actually changed since the last call are encoded.
 func EncodeLogTailMetricsDelta() string {
 	mu.Lock()
 	defer mu.Unlock()
@@ -0,0 +1,118 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clientmetric
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricVecWithDedupesConcurrently(t *testing.T) {
+	v := NewCounterVec("test_vec_dedup", "method", "code")
+
+	const goroutines = 50
+	got := make([]*Metric, goroutines)
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+			got[i] = v.With("GET", "200")
+		}()
+	}
+	wg.Wait()
+
+	first := got[0]
+	for i, m := range got {
+		if m != first {
+			t.Fatalf("goroutine %d got a different *Metric than goroutine 0; With did not dedupe", i)
+		}
+	}
+	if want := `test_vec_dedup{method="GET",code="200"}`; first.Name() != want {
+		t.Fatalf("Name() = %q, want %q", first.Name(), want)
+	}
+
+	// A distinct label combination gets its own child.
+	other := v.With("POST", "404")
+	if other == first {
+		t.Fatal("With with different label values returned the same *Metric")
+	}
+}
+
+func TestHistogramPrometheusFormat(t *testing.T) {
+	h := NewHistogram("test_hist_prom", []int64{10, 100})
+	h.Observe(5)   // <= 10
+	h.Observe(50)  // <= 100
+	h.Observe(500) // +Inf
+
+	var buf bytes.Buffer
+	WritePrometheusExpositionFormat(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE test_hist_prom histogram\n",
+		`test_hist_prom_bucket{le="10"} 1` + "\n",
+		`test_hist_prom_bucket{le="100"} 2` + "\n",
+		`test_hist_prom_bucket{le="+Inf"} 3` + "\n",
+		"test_hist_prom_sum 555\n",
+		"test_hist_prom_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exposition output missing %q; got:\n%s", want, out)
+		}
+	}
+
+	// The histogram's own family must not also get a per-child "#
+	// TYPE ... counter" header from its bucket/sum/count submetrics.
+	if strings.Contains(out, "test_hist_prom_bucket counter") || strings.Contains(out, "test_hist_prom_sum counter") {
+		t.Errorf("histogram submetrics leaked their own TYPE header; got:\n%s", out)
+	}
+}
+
+// flushPendingDelta drains any pending logtail delta (e.g. from
+// metrics other tests in this package created and changed), so that a
+// subsequent EncodeLogTailMetricsDelta call reflects only what
+// happens after flushPendingDelta returns.
+func flushPendingDelta(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	lastDelta = lastDelta.Add(-time.Hour) // dodge minMetricEncodeInterval
+	mu.Unlock()
+	EncodeLogTailMetricsDelta()
+}
+
+func TestEncodeLogTailMetricsDeltaHistogramChangedBucketsOnly(t *testing.T) {
+	// Flush any deltas left pending by other tests in this package
+	// (metrics are process-global), so what we observe below is
+	// solely the effect of our own Observe call.
+	flushPendingDelta(t)
+
+	h := NewHistogram("test_hist_delta", []int64{10, 100, 1000})
+
+	h.Observe(5) // only the le=10 bucket, sum, and count change
+
+	// Dodge minMetricEncodeInterval again: flushPendingDelta's call
+	// just set lastDelta to now.
+	mu.Lock()
+	lastDelta = lastDelta.Add(-time.Hour)
+	mu.Unlock()
+
+	delta := EncodeLogTailMetricsDelta()
+	if delta == "" {
+		t.Fatal("expected a non-empty delta after Observe")
+	}
+
+	// Exactly 3 wireIDs should have changed: the le=10 bucket, sum,
+	// and count. The le=100/le=1000/+Inf buckets didn't change and
+	// must not appear in the encoded delta at all.
+	n := strings.Count(delta, "I") + strings.Count(delta, "S")
+	if n != 3 {
+		t.Errorf("delta encoded %d changed metrics, want 3 (bucket le=10, sum, count); delta=%q", n, delta)
+	}
+}
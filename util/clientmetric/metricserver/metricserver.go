@@ -0,0 +1,317 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metricserver serves tailscale.com/util/clientmetric metrics
+// over HTTP, so that callers don't each have to wire up their own
+// http.Handler to expose them.
+//
+// By default a Server answers scrape ("pull") requests on /metrics
+// (Prometheus exposition format) and /debug/metrics (a JSON dump), plus
+// a /healthz liveness check. WithPushGateway additionally switches it
+// into "push" mode, periodically POSTing the same exposition body to a
+// Prometheus Pushgateway-compatible endpoint.
+package metricserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// Server answers /metrics, /debug/metrics and /healthz requests for
+// the process's clientmetric metrics. It's created by ListenAndServe
+// and must be shut down with Close.
+type Server struct {
+	hs   *http.Server
+	logf func(format string, args ...interface{})
+	addr string
+
+	// pushMaxRetries, pushInitialBackoff and pushHTTPTimeout tune
+	// pushOnce's retry behavior; they're fields rather than constants
+	// so tests can shrink them instead of waiting out the real backoff
+	// and timeout schedule.
+	pushMaxRetries     int
+	pushInitialBackoff time.Duration
+	pushHTTPTimeout    time.Duration
+
+	closeOnce sync.Once
+	pushDone  chan struct{} // closed by Close to stop the push loop, nil if not in push mode
+	pushWG    sync.WaitGroup
+}
+
+const (
+	defaultPushMaxRetries     = 5
+	defaultPushInitialBackoff = 500 * time.Millisecond
+	defaultPushHTTPTimeout    = 10 * time.Second
+)
+
+// Option configures optional behavior of a Server returned by
+// ListenAndServe.
+type Option func(*options)
+
+type options struct {
+	basicAuthUser, basicAuthPass string
+	tlsConfig                    *tls.Config
+	push                         *pushOptions
+}
+
+type pushOptions struct {
+	url      string
+	interval time.Duration
+	jobName  string
+}
+
+// WithBasicAuth requires user/pass HTTP Basic Auth on all requests.
+func WithBasicAuth(user, pass string) Option {
+	return func(o *options) {
+		o.basicAuthUser = user
+		o.basicAuthPass = pass
+	}
+}
+
+// WithTLS serves all endpoints over TLS using the provided config.
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = tlsConfig
+	}
+}
+
+// WithPushGateway switches the Server into push mode: in addition to
+// answering scrapes, it periodically POSTs its
+// current exposition-format metrics to a Prometheus Pushgateway
+// instance at url, as job jobName.
+func WithPushGateway(url string, interval time.Duration, jobName string) Option {
+	return func(o *options) {
+		o.push = &pushOptions{url: url, interval: interval, jobName: jobName}
+	}
+}
+
+// ListenAndServe starts serving the process's clientmetric metrics on
+// addr and returns immediately. The caller must call Close to shut the
+// server (and any push loop) down.
+//
+// It's safe to call ListenAndServe more than once, with different
+// addrs, to serve the same metrics on multiple listeners.
+func ListenAndServe(addr string, opts ...Option) (*Server, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := &Server{
+		logf:               log.Printf,
+		pushMaxRetries:     defaultPushMaxRetries,
+		pushInitialBackoff: defaultPushInitialBackoff,
+		pushHTTPTimeout:    defaultPushHTTPTimeout,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.HandleFunc("/debug/metrics", s.serveDebugMetrics)
+	mux.HandleFunc("/healthz", s.serveHealthz)
+
+	var h http.Handler = mux
+	if o.basicAuthUser != "" || o.basicAuthPass != "" {
+		h = basicAuthHandler(o.basicAuthUser, o.basicAuthPass, h)
+	}
+
+	s.hs = &http.Server{
+		Addr:      addr,
+		Handler:   h,
+		TLSConfig: o.tlsConfig,
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if o.tlsConfig != nil {
+		ln = tls.NewListener(ln, o.tlsConfig)
+	}
+	s.addr = ln.Addr().String()
+
+	go func() {
+		if err := s.hs.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.logf("metricserver: %v", err)
+		}
+	}()
+
+	if o.push != nil {
+		s.pushDone = make(chan struct{})
+		s.pushWG.Add(1)
+		go s.pushLoop(*o.push)
+	}
+
+	return s, nil
+}
+
+// Addr returns the address the Server is listening on, as a
+// host:port string. It's most useful when ListenAndServe was called
+// with a ":0" port, to discover which port was actually chosen.
+func (s *Server) Addr() string { return s.addr }
+
+// Close shuts the server down, including any push loop, and waits for
+// the push loop to stop. It closes the listener and active connections
+// but does not wait for in-flight HTTP handlers to return.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		err = s.hs.Close()
+		if s.pushDone != nil {
+			close(s.pushDone)
+		}
+		s.pushWG.Wait()
+	})
+	return err
+}
+
+func basicAuthHandler(user, pass string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotPass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(gotUser), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(gotPass), []byte(pass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tailscale metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	clientmetric.WritePrometheusExpositionFormat(w)
+}
+
+// debugMetric is the JSON form of a single metric, as served by
+// /debug/metrics.
+type debugMetric struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value int64  `json:"value"`
+}
+
+func (s *Server) serveDebugMetrics(w http.ResponseWriter, r *http.Request) {
+	ms := clientmetric.Metrics()
+	out := make([]debugMetric, 0, len(ms))
+	for _, m := range ms {
+		typ := "counter"
+		if m.Type() == clientmetric.TypeGauge {
+			typ = "gauge"
+		}
+		out = append(out, debugMetric{
+			Name:  m.Name(),
+			Type:  typ,
+			Value: m.Value(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
+}
+
+// pushFailures counts failed attempts (after all retries) to push
+// metrics to a Pushgateway. It's itself one of the metrics served by
+// /metrics, so a Pushgateway-based deployment can alert on its own
+// push health.
+var pushFailures = clientmetric.NewCounter("metricserver_push_failures")
+
+func (s *Server) pushLoop(o pushOptions) {
+	defer s.pushWG.Done()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	url := fmt.Sprintf("%s/metrics/job/%s/instance/%s", strings.TrimSuffix(o.url, "/"), o.jobName, hostname)
+
+	t := time.NewTicker(o.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.pushDone:
+			return
+		case <-t.C:
+			if err := s.pushOnce(url); err != nil {
+				pushFailures.Add(1)
+				s.logf("metricserver: push to %v failed: %v", o.url, err)
+			}
+		}
+	}
+}
+
+// pushOnce POSTs the current metrics to url, retrying with exponential
+// backoff until it succeeds, pushDone fires, or s.pushMaxRetries
+// attempts have failed, in which case it returns the last error.
+func (s *Server) pushOnce(url string) error {
+	backoff := s.pushInitialBackoff
+	var lastErr error
+	for attempt := 0; attempt < s.pushMaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-s.pushDone:
+				return lastErr
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err := s.pushAttempt(url); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// pushAttempt makes a single POST of the current metrics to url. The
+// request is bounded by s.pushHTTPTimeout and also aborted if pushDone
+// fires, so a pushgateway that black-holes the connection (or never
+// responds) can't hang pushOnce's retry loop or block Close from
+// returning.
+func (s *Server) pushAttempt(url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.pushHTTPTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.pushDone:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var buf bytes.Buffer
+	clientmetric.WritePrometheusExpositionFormat(&buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
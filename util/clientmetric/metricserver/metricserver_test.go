@@ -0,0 +1,214 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metricserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	s, err := ListenAndServe("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	resp, err := http.Get("http://" + s.Addr() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %v, want 200", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "ok\n" {
+		t.Fatalf("body = %q, want %q", body, "ok\n")
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	s, err := ListenAndServe("127.0.0.1:0", WithBasicAuth("alice", "hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	url := "http://" + s.Addr() + "/metrics"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("no credentials: status = %v, want 401", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", url, nil)
+	req.SetBasicAuth("alice", "wrong password")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("bad password: status = %v, want 401", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", url, nil)
+	req.SetBasicAuth("alice", "hunter2")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("correct credentials: status = %v, want 200", resp.StatusCode)
+	}
+}
+
+// countingPushGateway fails the first failUntil requests to
+// /metrics/job/... and succeeds thereafter, recording how many
+// requests it received.
+type countingPushGateway struct {
+	failUntil int
+	requests  int
+}
+
+func (p *countingPushGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.requests++
+	if p.requests <= p.failUntil {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func testServerForPush(t *testing.T) *Server {
+	t.Helper()
+	return &Server{
+		logf:               t.Logf,
+		pushDone:           make(chan struct{}),
+		pushMaxRetries:     3,
+		pushInitialBackoff: time.Millisecond,
+		pushHTTPTimeout:    50 * time.Millisecond,
+	}
+}
+
+func TestPushOnceRetriesThenSucceeds(t *testing.T) {
+	gw := &countingPushGateway{failUntil: 2}
+	ts := httptest.NewServer(gw)
+	defer ts.Close()
+
+	s := testServerForPush(t)
+	if err := s.pushOnce(ts.URL); err != nil {
+		t.Fatalf("pushOnce() = %v, want nil after eventually succeeding", err)
+	}
+	if gw.requests != 3 {
+		t.Fatalf("gateway got %d requests, want 3 (2 failures + 1 success)", gw.requests)
+	}
+}
+
+func TestPushOnceExhaustsRetries(t *testing.T) {
+	gw := &countingPushGateway{failUntil: 1000} // always fails
+	ts := httptest.NewServer(gw)
+	defer ts.Close()
+
+	s := testServerForPush(t)
+	err := s.pushOnce(ts.URL)
+	if err == nil {
+		t.Fatal("pushOnce() = nil, want an error when the gateway never succeeds")
+	}
+	if gw.requests != s.pushMaxRetries {
+		t.Fatalf("gateway got %d requests, want %d (pushMaxRetries)", gw.requests, s.pushMaxRetries)
+	}
+}
+
+// newHangingPushGateway returns a handler that accepts a request but
+// never writes a response, simulating a pushgateway host that's
+// reachable but stuck (e.g. a firewall black-holing the connection
+// after the handshake), plus a release func the test must call before
+// its httptest.Server is closed: Server.Close waits for in-flight
+// handlers to return, and nothing but release (or the client severing
+// the connection) ever unblocks one.
+func newHangingPushGateway() (handler http.HandlerFunc, release func()) {
+	stop := make(chan struct{})
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}, func() { close(stop) }
+}
+
+func TestPushOnceTimesOutOnHungGateway(t *testing.T) {
+	gw, release := newHangingPushGateway()
+	ts := httptest.NewServer(gw)
+	defer ts.Close()
+	defer release()
+
+	s := testServerForPush(t)
+
+	done := make(chan error, 1)
+	go func() { done <- s.pushOnce(ts.URL) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("pushOnce() = nil, want an error from a gateway that never responds")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushOnce() did not return within 5s of a hung gateway; each attempt should be bounded by pushHTTPTimeout")
+	}
+}
+
+func TestPushOnceAbortsOnPushDone(t *testing.T) {
+	gw, release := newHangingPushGateway()
+	ts := httptest.NewServer(gw)
+	defer ts.Close()
+	defer release()
+
+	s := testServerForPush(t)
+	s.pushHTTPTimeout = time.Minute // would hang long past the test's patience if pushDone didn't also cancel it
+
+	done := make(chan error, 1)
+	go func() { done <- s.pushOnce(ts.URL) }()
+
+	close(s.pushDone)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushOnce() did not return within 5s of pushDone closing; a hung request should be aborted, not just waited out by backoff")
+	}
+}
+
+func TestPushURLPath(t *testing.T) {
+	var gotPath string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	s := testServerForPush(t)
+	url := fmt.Sprintf("%s/metrics/job/%s/instance/%s", ts.URL, "myjob", "myhost")
+	if err := s.pushOnce(url); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/metrics/job/myjob/instance/myhost"; gotPath != want {
+		t.Fatalf("gateway saw path %q, want %q", gotPath, want)
+	}
+}
@@ -51,13 +51,75 @@ func cidrIsSubnet(node *tailcfg.Node, cidr netaddr.IPPrefix) bool {
 	return true
 }
 
+// defaultRouteBucket is the RouteBucket used for all routes when no
+// RouteSelector is given to WGCfgMulti, matching WGCfg's single-config
+// behavior.
+const defaultRouteBucket RouteBucket = ""
+
+// RouteBucket names one of possibly several wireguard configs (and,
+// typically, the routing table or OS interface that's programmed from
+// it) that a peer's AllowedIPs can be assigned to. The zero value is
+// the bucket used when no RouteSelector is configured.
+type RouteBucket string
+
+// RouteSelector decides which RouteBucket a peer's AllowedIP (cidr)
+// should be placed into. It's used by WGCfgMulti to split a netmap's
+// routes across multiple wireguard configs, enabling policy-based
+// routing setups such as sending subnet routes through one interface
+// while keeping mesh peer-to-peer traffic on another.
+type RouteSelector func(peer *tailcfg.Node, cidr netaddr.IPPrefix) RouteBucket
+
 // WGCfg returns the NetworkMaps's Wireguard configuration.
 func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags, exitNode tailcfg.StableNodeID) (*wgcfg.Config, error) {
-	cfg := &wgcfg.Config{
-		Name:       "tailscale",
-		PrivateKey: nm.PrivateKey,
-		Addresses:  nm.Addresses,
-		Peers:      make([]wgcfg.Peer, 0, len(nm.Peers)),
+	cfgs, err := WGCfgMulti(nm, logf, flags, exitNode, nil)
+	if err != nil {
+		return nil, err
+	}
+	return cfgs[defaultRouteBucket], nil
+}
+
+// WGCfgMulti is like WGCfg, but takes a RouteSelector that splits the
+// resulting AllowedIPs across one *wgcfg.Config per RouteBucket. A
+// peer is duplicated across buckets as needed, with each copy
+// carrying only the AllowedIPs sel assigned to that bucket; a peer
+// with no AllowedIPs routed to a given bucket is omitted from it
+// entirely.
+//
+// If sel is nil, every route goes to the zero RouteBucket, and the
+// returned map has exactly one entry, matching WGCfg's single-config
+// behavior (including its inclusion of peers with no AllowedIPs at
+// all).
+func WGCfgMulti(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags, exitNode tailcfg.StableNodeID, sel RouteSelector) (map[RouteBucket]*wgcfg.Config, error) {
+	// peersCap is the initial capacity to preallocate each bucket's
+	// Peers slice with. With no selector there's only ever one
+	// bucket, holding up to every peer, so it's worth sizing exactly
+	// as WGCfg always did. With a selector, a real RouteBucket
+	// typically holds a strict subset of peers, so over-allocating
+	// len(nm.Peers) per bucket would waste O(buckets * len(Peers));
+	// let append grow those from zero instead.
+	peersCap := 0
+	if sel == nil {
+		peersCap = len(nm.Peers)
+	}
+
+	cfgs := map[RouteBucket]*wgcfg.Config{}
+	cfgFor := func(b RouteBucket) *wgcfg.Config {
+		cfg, ok := cfgs[b]
+		if !ok {
+			cfg = &wgcfg.Config{
+				Name:       "tailscale",
+				PrivateKey: nm.PrivateKey,
+				Addresses:  nm.Addresses,
+				Peers:      make([]wgcfg.Peer, 0, peersCap),
+			}
+			cfgs[b] = cfg
+		}
+		return cfg
+	}
+	if sel == nil {
+		// Force the default bucket to exist even with zero peers, to
+		// match WGCfg's old behavior of always returning a config.
+		cfgFor(defaultRouteBucket)
 	}
 
 	// Logging buffers
@@ -72,13 +134,31 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 			logf("[v1] wgcfg: skipped peer %s, doesn't offer DERP or disco", peer.Key.ShortString())
 			continue
 		}
-		cfg.Peers = append(cfg.Peers, wgcfg.Peer{
-			PublicKey: key.NodePublicFromRaw32(mem.B(peer.Key[:])),
-			DiscoKey:  peer.DiscoKey,
-		})
-		cpeer := &cfg.Peers[len(cfg.Peers)-1]
-		if peer.KeepAlive {
-			cpeer.PersistentKeepalive = 25 // seconds
+
+		// peerFor returns (creating if needed) this netmap peer's
+		// *wgcfg.Peer in bucket b, so that multiple AllowedIPs routed
+		// to the same bucket share one entry.
+		bucketPeers := map[RouteBucket]*wgcfg.Peer{}
+		peerFor := func(b RouteBucket) *wgcfg.Peer {
+			if p, ok := bucketPeers[b]; ok {
+				return p
+			}
+			cfg := cfgFor(b)
+			cfg.Peers = append(cfg.Peers, wgcfg.Peer{
+				PublicKey: key.NodePublicFromRaw32(mem.B(peer.Key[:])),
+				DiscoKey:  peer.DiscoKey,
+			})
+			cpeer := &cfg.Peers[len(cfg.Peers)-1]
+			if peer.KeepAlive {
+				cpeer.PersistentKeepalive = 25 // seconds
+			}
+			bucketPeers[b] = cpeer
+			return cpeer
+		}
+		if sel == nil {
+			// Preserve WGCfg's old behavior of including every peer,
+			// even ones that end up with no AllowedIPs.
+			peerFor(defaultRouteBucket)
 		}
 
 		didExitNodeWarn := false
@@ -109,6 +189,12 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 					continue
 				}
 			}
+
+			b := defaultRouteBucket
+			if sel != nil {
+				b = sel(peer, allowedIP)
+			}
+			cpeer := peerFor(b)
 			cpeer.AllowedIPs = append(cpeer.AllowedIPs, allowedIP)
 		}
 	}
@@ -123,5 +209,5 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 		logf("[v1] wgcfg: did not accept subnet routes: %s", skippedSubnets)
 	}
 
-	return cfg, nil
+	return cfgs, nil
 }
@@ -0,0 +1,185 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nmcfg
+
+import (
+	"testing"
+
+	"go4.org/mem"
+	"inet.af/netaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+func discardLogf(format string, args ...interface{}) {}
+
+// wgPublicKey returns the wgcfg public key nmcfg derives from n, the
+// same way WGCfg/WGCfgMulti do, so tests can match a *wgcfg.Config
+// peer back to the tailcfg.Node it came from.
+func wgPublicKey(n *tailcfg.Node) key.NodePublic {
+	return key.NodePublicFromRaw32(mem.B(n.Key[:]))
+}
+
+// mkNode builds a minimal tailcfg.Node suitable for use as a
+// WGCfg/WGCfgMulti peer: it has a non-zero DiscoKey, so it isn't
+// skipped for predating discovery.
+func mkNode(id byte, stableID tailcfg.StableNodeID, allowed ...netaddr.IPPrefix) *tailcfg.Node {
+	var key tailcfg.NodeKey
+	key[0] = id
+	var disco tailcfg.DiscoKey
+	disco[0] = id
+	return &tailcfg.Node{
+		Key:        key,
+		DiscoKey:   disco,
+		StableID:   stableID,
+		Name:       string(stableID) + ".example.ts.net",
+		AllowedIPs: allowed,
+	}
+}
+
+func TestWGCfgSingleConfigRegression(t *testing.T) {
+	node := mkNode(1, "node1") // no AllowedIPs at all
+	nm := &netmap.NetworkMap{Peers: []*tailcfg.Node{node}}
+
+	cfg, err := WGCfg(nm, discardLogf, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// WGCfg has always included every non-skipped peer, even ones
+	// that end up with no AllowedIPs at all; WGCfgMulti's lazy,
+	// omit-if-empty bucket creation must not change that when sel is
+	// nil.
+	if len(cfg.Peers) != 1 {
+		t.Fatalf("got %d peers, want 1 (present even with zero AllowedIPs)", len(cfg.Peers))
+	}
+	if len(cfg.Peers[0].AllowedIPs) != 0 {
+		t.Fatalf("got %d AllowedIPs, want 0", len(cfg.Peers[0].AllowedIPs))
+	}
+}
+
+func TestWGCfgSkipsPeerWithoutDiscoOrDERP(t *testing.T) {
+	node := mkNode(1, "node1", netaddr.MustParseIPPrefix("100.64.0.2/32"))
+	node.DiscoKey = tailcfg.DiscoKey{} // zero: predates disco
+	node.DERP = ""
+	nm := &netmap.NetworkMap{Peers: []*tailcfg.Node{node}}
+
+	cfg, err := WGCfg(nm, discardLogf, netmap.AllowSingleHosts, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Peers) != 0 {
+		t.Fatalf("got %d peers, want 0 (peer predates both DERP and disco)", len(cfg.Peers))
+	}
+}
+
+func TestWGCfgMultiExitNodeBucket(t *testing.T) {
+	ipv4Default := netaddr.MustParseIPPrefix("0.0.0.0/0")
+	ipv6Default := netaddr.MustParseIPPrefix("::/0")
+	meshOnly := netaddr.MustParseIPPrefix("100.64.0.2/32")
+
+	const exitID tailcfg.StableNodeID = "exit"
+	const meshID tailcfg.StableNodeID = "mesh"
+
+	exitNode := mkNode(1, exitID, ipv4Default, ipv6Default)
+	meshNode := mkNode(2, meshID, meshOnly)
+	nm := &netmap.NetworkMap{Peers: []*tailcfg.Node{exitNode, meshNode}}
+
+	const exitBucket RouteBucket = "exit"
+	const meshBucket RouteBucket = "mesh"
+	sel := func(peer *tailcfg.Node, cidr netaddr.IPPrefix) RouteBucket {
+		if cidr.Bits() == 0 {
+			return exitBucket
+		}
+		return meshBucket
+	}
+
+	cfgs, err := WGCfgMulti(nm, discardLogf, netmap.AllowSingleHosts|netmap.AllowSubnetRoutes, exitID, sel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(cfgs))
+	}
+
+	exitCfg, ok := cfgs[exitBucket]
+	if !ok {
+		t.Fatal("missing exit bucket")
+	}
+	if len(exitCfg.Peers) != 1 {
+		t.Fatalf("exit bucket has %d peers, want 1 (only the exit node, for its 0/0 routes)", len(exitCfg.Peers))
+	}
+	if got, want := exitCfg.Peers[0].PublicKey, wgPublicKey(exitNode); got != want {
+		t.Fatalf("exit bucket's only peer is %v, want the exit node %v", got, want)
+	}
+	if got := len(exitCfg.Peers[0].AllowedIPs); got != 2 {
+		t.Fatalf("exit bucket peer has %d AllowedIPs, want 2 (the v4 and v6 default routes)", got)
+	}
+
+	meshCfg, ok := cfgs[meshBucket]
+	if !ok {
+		t.Fatal("missing mesh bucket")
+	}
+	if len(meshCfg.Peers) != 1 {
+		t.Fatalf("mesh bucket has %d peers, want 1 (only the mesh node)", len(meshCfg.Peers))
+	}
+	if got, want := meshCfg.Peers[0].PublicKey, wgPublicKey(meshNode); got != want {
+		t.Fatalf("mesh bucket's only peer is %v, want the mesh node %v", got, want)
+	}
+
+	// The exit node must not leak into the mesh bucket: its only
+	// AllowedIPs are the 0/0 routes, which the selector sent
+	// elsewhere.
+	for _, p := range meshCfg.Peers {
+		if p.PublicKey == wgPublicKey(exitNode) {
+			t.Error("exit node found in the mesh bucket; its 0/0 routes should be the only ones it carries, and those went to the exit bucket")
+		}
+	}
+}
+
+func TestWGCfgMultiOverlappingSubnetAcrossBuckets(t *testing.T) {
+	subnet := netaddr.MustParseIPPrefix("10.0.0.0/24")
+
+	const routerAID tailcfg.StableNodeID = "routerA"
+	const routerBID tailcfg.StableNodeID = "routerB"
+	routerA := mkNode(1, routerAID, subnet)
+	routerB := mkNode(2, routerBID, subnet)
+	nm := &netmap.NetworkMap{Peers: []*tailcfg.Node{routerA, routerB}}
+
+	const bucketA RouteBucket = "a"
+	const bucketB RouteBucket = "b"
+	sel := func(peer *tailcfg.Node, cidr netaddr.IPPrefix) RouteBucket {
+		if peer.StableID == routerAID {
+			return bucketA
+		}
+		return bucketB
+	}
+
+	cfgs, err := WGCfgMulti(nm, discardLogf, netmap.AllowSubnetRoutes, "", sel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(cfgs))
+	}
+
+	a, b := cfgs[bucketA], cfgs[bucketB]
+	if len(a.Peers) != 1 || len(a.Peers[0].AllowedIPs) != 1 || a.Peers[0].AllowedIPs[0] != subnet {
+		t.Fatalf("bucket a = %+v, want exactly routerA advertising %v", a, subnet)
+	}
+	if got, want := a.Peers[0].PublicKey, wgPublicKey(routerA); got != want {
+		t.Fatalf("bucket a's peer is %v, want routerA %v", got, want)
+	}
+	if len(b.Peers) != 1 || len(b.Peers[0].AllowedIPs) != 1 || b.Peers[0].AllowedIPs[0] != subnet {
+		t.Fatalf("bucket b = %+v, want exactly routerB advertising %v", b, subnet)
+	}
+	if got, want := b.Peers[0].PublicKey, wgPublicKey(routerB); got != want {
+		t.Fatalf("bucket b's peer is %v, want routerB %v", got, want)
+	}
+	// The same overlapping subnet showing up in two different buckets
+	// isn't a conflict to dedupe away: each bucket is an independent
+	// routing table, and each carries only the peer the selector
+	// actually assigned that CIDR to.
+}